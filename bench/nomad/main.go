@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/gob"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"../../status"
@@ -15,6 +24,14 @@ import (
 	"github.com/hashicorp/nomad/nomad/structs"
 )
 
+// jobManifest is the name of the file written by handleSetup that lists the
+// jobspec files handleRun should submit. It lets setup produce more than one
+// job template (e.g. a driver matrix) without handleRun having to guess.
+const jobManifest = "job.manifest"
+
+// driverMatrix enumerates the drivers exercised by the -matrix setup mode.
+var driverMatrix = []string{"docker", "exec", "raw_exec", "java"}
+
 // Exec interface:
 //   setup(numJobs, numContainers int) string
 //   run(dir string, numJobs, numContainers int)
@@ -43,39 +60,286 @@ func main() {
 }
 
 func handleSetup() int {
-	// Check the args
-	if len(os.Args) != 2 {
-		log.Fatalln("usage: nomad-bench setup")
+	flags := flag.NewFlagSet("setup", flag.ExitOnError)
+	jobspecPath := flags.String("jobspec", os.Getenv("NOMAD_BENCH_JOBSPEC"),
+		"path to an HCL jobspec to use instead of the built-in Redis/Docker template")
+	matrix := flags.Bool("matrix", false,
+		"generate a driver matrix (docker, exec, raw_exec, java) instead of a single jobspec")
+	cpu := flags.Int("cpu", envIntDefault("NOMAD_BENCH_CPU", 100), "CPU (MHz) to request per task, matrix mode only")
+	memory := flags.Int("memory", envIntDefault("NOMAD_BENCH_MEMORY", 100), "memory (MB) to request per task, matrix mode only")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("failed parsing flags: %v", err)
 	}
 
-	// Parse the inputs
+	var jobFiles []string
 	var err error
-	var numContainers int
+	switch {
+	case *matrix:
+		// Only the matrix path needs NOMAD_NUM_CONTAINERS; the jobspec and
+		// default paths either don't need a count or look it up themselves.
+		v := os.Getenv("NOMAD_NUM_CONTAINERS")
+		numContainers, convErr := strconv.Atoi(v)
+		if convErr != nil {
+			log.Fatalln("NOMAD_NUM_CONTAINERS must be numeric")
+		}
 
-	v := os.Getenv("NOMAD_NUM_CONTAINERS")
-	if numContainers, err = strconv.Atoi(v); err != nil {
-		log.Fatalln("NOMAD_NUM_CONTAINERS must be numeric")
+		jobFiles, err = writeDriverMatrix(numContainers, *cpu, *memory)
+		if err != nil {
+			log.Fatalf("failed writing driver matrix: %v", err)
+		}
+	case *jobspecPath != "":
+		jobFiles, err = writeJobFromTemplate(*jobspecPath)
+		if err != nil {
+			log.Fatalf("failed loading jobspec %q: %v", *jobspecPath, err)
+		}
+	default:
+		jobFiles, err = writeJobFromTemplate("")
+		if err != nil {
+			log.Fatalf("failed writing job file: %v", err)
+		}
+	}
+
+	if err := writeJobManifest(jobFiles); err != nil {
+		log.Fatalf("failed writing job manifest: %v", err)
 	}
+	return 0
+}
 
-	// Create the job file
-	fh, err := os.Create("job.nomad")
+// envIntDefault returns the integer value of the named environment variable,
+// or def if it is unset. A non-numeric value is fatal, matching the strict
+// parsing handleSetup already applies to NOMAD_NUM_CONTAINERS.
+func envIntDefault(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
 	if err != nil {
-		log.Fatalf("failed creating job file: %v", err)
+		log.Fatalf("%s must be numeric", name)
+	}
+	return n
+}
+
+// writeJobFromTemplate writes a single job.nomad file. If path is non-empty
+// it is validated with jobspec.ParseFile and copied verbatim; otherwise the
+// built-in Redis/Docker template is rendered with the count read from
+// NOMAD_NUM_CONTAINERS.
+func writeJobFromTemplate(path string) ([]string, error) {
+	const out = "job.nomad"
+
+	var content string
+	if path != "" {
+		if _, err := jobspec.ParseFile(path); err != nil {
+			return nil, fmt.Errorf("invalid jobspec: %v", err)
+		}
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		content = string(raw)
+	}
+
+	fh, err := os.Create(out)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating job file: %v", err)
 	}
 	defer fh.Close()
 
-	// Write the job contents
-	jobContent := fmt.Sprintf(jobTemplate, numContainers)
-	if _, err := fh.WriteString(jobContent); err != nil {
-		log.Fatalf("failed writing to job file: %v", err)
+	if content == "" {
+		v := os.Getenv("NOMAD_NUM_CONTAINERS")
+		numContainers, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("NOMAD_NUM_CONTAINERS must be numeric")
+		}
+		content = fmt.Sprintf(jobTemplate, numContainers)
 	}
-	return 0
+
+	if _, err := fh.WriteString(content); err != nil {
+		return nil, fmt.Errorf("failed writing to job file: %v", err)
+	}
+	return []string{out}, nil
+}
+
+// writeDriverMatrix renders one job file per driver in driverMatrix so the
+// harness can submit a mix of docker, exec, raw_exec, and java workloads
+// instead of a single uniform job.
+func writeDriverMatrix(count, cpu, memory int) ([]string, error) {
+	var files []string
+	for _, driver := range driverMatrix {
+		name := fmt.Sprintf("job-%s.nomad", driver)
+		fh, err := os.Create(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed creating job file: %v", err)
+		}
+
+		content := fmt.Sprintf(driverJobTemplate(driver), driver, count, cpu, memory)
+		_, writeErr := fh.WriteString(content)
+		fh.Close()
+		if writeErr != nil {
+			return nil, fmt.Errorf("failed writing to job file: %v", writeErr)
+		}
+		files = append(files, name)
+	}
+	return files, nil
+}
+
+// writeJobManifest records the jobspec files handleRun should submit so
+// setup and run stay in sync regardless of which mode generated them.
+func writeJobManifest(files []string) error {
+	fh, err := os.Create(jobManifest)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	_, err = fh.WriteString(strings.Join(files, "\n") + "\n")
+	return err
+}
+
+// readJobManifest returns the jobspec files to submit, falling back to the
+// legacy single job.nomad file for dirs created before manifests existed.
+func readJobManifest() ([]string, error) {
+	fh, err := os.Open(jobManifest)
+	if os.IsNotExist(err) {
+		return []string{"job.nomad"}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var files []string
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// nomadClientFlags holds the cluster-connection settings shared by every
+// subcommand that talks to Nomad (run, status, teardown). Each except
+// waitTime defaults from the corresponding Nomad environment variable so the
+// bench behaves like any other Nomad CLI tool out of the box; waitTime
+// defaults to 5s.
+type nomadClientFlags struct {
+	address    string
+	region     string
+	caCert     string
+	clientCert string
+	clientKey  string
+	httpAuth   string
+	waitTime   time.Duration
+}
+
+// registerNomadFlags registers the shared Nomad connection flags on flags
+// and returns the struct they populate once flags.Parse is called.
+func registerNomadFlags(flags *flag.FlagSet) *nomadClientFlags {
+	f := &nomadClientFlags{}
+	flags.StringVar(&f.address, "address", os.Getenv("NOMAD_ADDR"), "Nomad HTTP API address")
+	flags.StringVar(&f.region, "region", os.Getenv("NOMAD_REGION"), "Nomad region")
+	flags.StringVar(&f.caCert, "ca-cert", os.Getenv("NOMAD_CACERT"), "path to a PEM-encoded CA certificate file")
+	flags.StringVar(&f.clientCert, "client-cert", os.Getenv("NOMAD_CLIENT_CERT"), "path to a PEM-encoded client certificate")
+	flags.StringVar(&f.clientKey, "client-key", os.Getenv("NOMAD_CLIENT_KEY"), "path to a PEM-encoded client certificate key")
+	flags.StringVar(&f.httpAuth, "http-auth", os.Getenv("NOMAD_HTTP_AUTH"), "HTTP basic auth credentials as 'username:password'")
+	flags.DurationVar(&f.waitTime, "wait-time", 5*time.Second, "Nomad blocking query wait time")
+	return f
+}
+
+// newNomadClient builds an api.Client from f, laying TLS and auth settings
+// on top of api.DefaultConfig() so the bench can drive secured or
+// multi-region clusters the same way any other Nomad API consumer does.
+// api.Config itself has no TLS or auth knobs, so both are implemented as an
+// http.RoundTripper installed on cfg.HttpClient.
+func newNomadClient(f *nomadClientFlags) (*api.Client, error) {
+	cfg := api.DefaultConfig()
+	if f.address != "" {
+		cfg.Address = f.address
+	}
+	if f.region != "" {
+		cfg.Region = f.region
+	}
+
+	var transport http.RoundTripper
+	if f.caCert != "" || f.clientCert != "" || f.clientKey != "" {
+		tlsConfig, err := buildTLSConfig(f.caCert, f.clientCert, f.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	if f.httpAuth != "" {
+		username := f.httpAuth
+		password := ""
+		if idx := strings.IndexByte(f.httpAuth, ':'); idx >= 0 {
+			username, password = f.httpAuth[:idx], f.httpAuth[idx+1:]
+		}
+		transport = &basicAuthTransport{username: username, password: password, next: transport}
+	}
+	if transport != nil {
+		cfg.HttpClient = &http.Client{Transport: transport}
+	}
+
+	return api.NewClient(cfg)
+}
+
+// buildTLSConfig assembles a tls.Config from PEM file paths, any of which
+// may be empty.
+func buildTLSConfig(caCert, clientCert, clientKey string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if caCert != "" {
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading CA cert: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("failed parsing CA cert %q", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if clientCert != "" && clientKey != "" {
+		cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed loading client cert/key: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// basicAuthTransport adds HTTP basic auth to every request before handing
+// it off to next (http.DefaultTransport if nil).
+type basicAuthTransport struct {
+	username, password string
+	next               http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req.SetBasicAuth(t.username, t.password)
+	next := t.next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return next.RoundTrip(req)
 }
 
+// handleRun submits jobs from a concurrent, rate-limited worker pool. It
+// deliberately does not call jobs.Plan before jobs.Register to record a
+// dry-run/diff cost as its own metric: the vendored Nomad API this bench
+// targets (v0.3.2) has no Plan method on *api.Jobs and no /v1/jobs/plan
+// route, so that half of the original request isn't implementable here.
 func handleRun() int {
-	// Check the args
-	if len(os.Args) != 2 {
-		log.Fatalln("usage: nomad-bench run")
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory shared with `status` for the submission-time index")
+	statusAddr := flags.String("status", os.Getenv("NOMAD_BENCH_STATUS_ADDR"), "status server address for submission metrics (optional)")
+	concurrency := flags.Int("concurrency", envIntDefault("NOMAD_BENCH_SUBMIT_CONCURRENCY", 1), "number of concurrent submitters")
+	qps := flags.Float64("qps", envFloatDefault("NOMAD_BENCH_SUBMIT_QPS", 0), "submission rate limit in jobs/sec (0 = unlimited)")
+	nomadFlags := registerNomadFlags(flags)
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("failed parsing flags: %v", err)
 	}
 
 	// Parse the inputs
@@ -86,58 +350,264 @@ func handleRun() int {
 	if numJobs, err = strconv.Atoi(v); err != nil {
 		log.Fatalln("NOMAD_NUM_JOBS must be numeric")
 	}
+	if numJobs < 0 {
+		log.Printf("NOMAD_NUM_JOBS is negative (%d); submitting nothing", numJobs)
+		numJobs = 0
+	}
 
-	// Parse the job file
-	job, err := jobspec.ParseFile("job.nomad")
+	// Parse the job files produced by setup. Usually this is the single
+	// job.nomad template, but a driver matrix setup produces several.
+	jobFiles, err := readJobManifest()
 	if err != nil {
-		log.Fatalf("failed parsing job file: %v", err)
+		log.Fatalf("failed reading job manifest: %v", err)
 	}
 
-	// Convert to an API struct for submission
-	apiJob, err := convertStructJob(job)
-	if err != nil {
-		log.Fatalf("failed converting job: %v", err)
+	var apiJobs []*api.Job
+	for _, f := range jobFiles {
+		job, err := jobspec.ParseFile(f)
+		if err != nil {
+			log.Fatalf("failed parsing job file %q: %v", f, err)
+		}
+
+		apiJob, err := convertStructJob(job)
+		if err != nil {
+			log.Fatalf("failed converting job %q: %v", f, err)
+		}
+		apiJobs = append(apiJobs, apiJob)
 	}
 
 	// Get the API client
-	client, err := api.NewClient(api.DefaultConfig())
+	client, err := newNomadClient(nomadFlags)
 	if err != nil {
 		log.Fatalf("failed creating nomad client: %v", err)
 	}
 	jobs := client.Jobs()
 
-	// Submit the job the requested number of times
-	for i := 0; i < numJobs; i++ {
-		// Increment the job ID
-		apiJob.ID = fmt.Sprintf("job-%d", i)
-		if _, _, err := jobs.Register(apiJob, nil); err != nil {
-			log.Fatalf("failed registering jobs: %v", err)
+	// Optionally report submission metrics to the status server so
+	// submitter throughput can be distinguished from scheduler throughput.
+	var statusClient *status.Client
+	if *statusAddr != "" {
+		statusClient, err = status.NewClient(*statusAddr)
+		if err != nil {
+			log.Fatalf("failed contacting status server: %v", err)
 		}
+		defer statusClient.Close()
+	}
+
+	limiter := newTokenBucket(*qps)
+
+	var mu sync.Mutex
+	submissions := make(submitIndex, numJobs)
+	registerLatency := new(latencyHistogram)
+	var errCount int64
+
+	// Submit the jobs from a worker pool, cycling through the template
+	// matrix so the load is spread across all driver types. Workers pull
+	// indices off a shared channel rather than dividing the range up front
+	// so a slow worker doesn't leave the others idle.
+	indices := make(chan int, numJobs)
+	for i := 0; i < numJobs; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	var wg sync.WaitGroup
+	for w := 0; w < *concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				limiter.take()
+
+				base := apiJobs[i%len(apiJobs)]
+				apiJob := new(api.Job)
+				*apiJob = *base
+				apiJob.ID = fmt.Sprintf("job-%d", i)
+
+				ts := time.Now().UTC()
+				regStart := time.Now()
+				_, _, err := jobs.Register(apiJob, nil)
+
+				mu.Lock()
+				registerLatency.record(time.Since(regStart))
+				if err != nil {
+					errCount++
+					log.Printf("failed registering job %q: %v", apiJob.ID, err)
+				} else {
+					submissions[apiJob.ID] = ts
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := writeSubmitIndex(*dir, submissions); err != nil {
+		log.Fatalf("failed writing submission index: %v", err)
+	}
+
+	if statusClient != nil {
+		ts := time.Now().UTC()
+		registerLatency.emit(statusClient, "submit.register", ts)
+		statusClient.Set("submit.errors", float64(errCount), ts)
 	}
 
 	return 0
 }
 
+// envFloatDefault returns the float64 value of the named environment
+// variable, or def if it is unset.
+func envFloatDefault(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("%s must be numeric", name)
+	}
+	return f
+}
+
+// envBoolDefault returns the boolean value of the named environment
+// variable, or def if it is unset.
+func envBoolDefault(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("%s must be a boolean", name)
+	}
+	return b
+}
+
+// envDurationDefault returns the time.Duration value of the named
+// environment variable, or def if it is unset.
+func envDurationDefault(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("%s must be a duration", name)
+	}
+	return d
+}
+
+// tokenBucket is a minimal token-bucket rate limiter used to cap submission
+// QPS. A nil *tokenBucket (qps <= 0) never blocks.
+type tokenBucket struct {
+	tokens chan struct{}
+}
+
+func newTokenBucket(qps float64) *tokenBucket {
+	if qps <= 0 {
+		return nil
+	}
+
+	size := int(qps)
+	if size < 1 {
+		size = 1
+	}
+
+	tb := &tokenBucket{tokens: make(chan struct{}, size)}
+	for i := 0; i < size; i++ {
+		tb.tokens <- struct{}{}
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / qps))
+		defer ticker.Stop()
+		for range ticker.C {
+			select {
+			case tb.tokens <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return tb
+}
+
+func (tb *tokenBucket) take() {
+	if tb == nil {
+		return
+	}
+	<-tb.tokens
+}
+
+// submitIndex maps a job ID to the time its submission request was sent. It
+// is shared between `run` and `status` as a gob-encoded file in the bench
+// directory so status can report submit-to-placement latency.
+type submitIndex map[string]time.Time
+
+const submitIndexFile = "submit.index"
+
+func writeSubmitIndex(dir string, idx submitIndex) error {
+	fh, err := os.Create(filepath.Join(dir, submitIndexFile))
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	return gob.NewEncoder(fh).Encode(idx)
+}
+
+func readSubmitIndex(dir string) (submitIndex, error) {
+	fh, err := os.Open(filepath.Join(dir, submitIndexFile))
+	if os.IsNotExist(err) {
+		return submitIndex{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	idx := make(submitIndex)
+	if err := gob.NewDecoder(fh).Decode(&idx); err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
 func handleStatus() int {
-	// Check the args
-	if len(os.Args) != 3 {
-		log.Fatalln("usage: nomad-bench status <addr>")
+	flags := flag.NewFlagSet("status", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory shared with `run` holding the submission-time index")
+	nomadFlags := registerNomadFlags(flags)
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("failed parsing flags: %v", err)
+	}
+	if flags.NArg() != 1 {
+		log.Fatalln("usage: nomad-bench status [-dir <dir>] <addr>")
 	}
 
 	// Get the API client
-	client, err := api.NewClient(api.DefaultConfig())
+	client, err := newNomadClient(nomadFlags)
 	if err != nil {
 		log.Fatalf("failed creating nomad client: %v", err)
 	}
 	allocs := client.Allocations()
 
 	// Get the status client
-	statusClient, err := status.NewClient(os.Args[2])
+	statusClient, err := status.NewClient(flags.Arg(0))
 	if err != nil {
 		log.Fatalf("failed contacting status server: %v", err)
 	}
 	defer statusClient.Close()
 
+	// Submission times recorded by `run`, keyed by job ID, used to compute
+	// submit->received latency below. It's read once; if `run` hasn't
+	// written it yet those samples are simply skipped, while a file that
+	// fails to decode is fatal.
+	submissions, err := readSubmitIndex(*dir)
+	if err != nil {
+		log.Fatalf("failed reading submission index: %v", err)
+	}
+
+	tasks := newTaskTracker()
+	latencies := newLatencyTracker()
+
 	// Wait loop for allocation statuses
 	var lastPending, lastRunning, lastTotal int64
 	var index uint64 = 1
@@ -146,6 +616,7 @@ func handleStatus() int {
 		args := &api.QueryOptions{
 			AllowStale: true,
 			WaitIndex:  index,
+			WaitTime:   nomadFlags.waitTime,
 		}
 
 		// Start the query
@@ -175,6 +646,12 @@ func handleStatus() int {
 			case structs.AllocClientStatusRunning:
 				allocsRunning++
 			}
+
+			tasks.observe(alloc, submissions[alloc.JobID], latencies)
+
+			if alloc.ClientStatus == structs.AllocClientStatusRunning {
+				latencies.recordRunning(alloc.ID, ts)
+			}
 		}
 
 		// Write the metrics, if there were changes.
@@ -190,42 +667,287 @@ func handleStatus() int {
 			lastRunning = allocsRunning
 			statusClient.Set("running", float64(allocsRunning), ts)
 		}
+
+		tasks.emit(statusClient, ts)
+		latencies.emit(statusClient, ts)
 	}
 
 	return 0
 }
 
+// taskTracker keeps the count of task-state events already processed per
+// allocation/task so that repeated blocking queries only process new
+// transitions, plus a running count of tasks currently sitting in each
+// event state.
+type taskTracker struct {
+	processed map[string]map[string]int // allocID -> taskName -> len(Events) already processed
+	counts    map[string]int64          // TaskEvent.Type -> number of tasks last seen in that state
+	lastSent  map[string]int64          // TaskEvent.Type -> last value sent to the status server
+}
+
+func newTaskTracker() *taskTracker {
+	return &taskTracker{
+		processed: make(map[string]map[string]int),
+		counts:    make(map[string]int64),
+		lastSent:  make(map[string]int64),
+	}
+}
+
+// observe walks an allocation's task states, advances per-task-state counts
+// for any new transitions, and feeds submit->received and received->started
+// samples into latencies. It processes every event newer than the last one
+// seen, not just the latest, so a burst of transitions landing between two
+// blocking-query responses (e.g. Received and Started both arriving before
+// the next poll) isn't collapsed down to a single sample.
+func (t *taskTracker) observe(alloc *api.AllocationListStub, submitted time.Time, latencies *latencyTracker) {
+	for name, state := range alloc.TaskStates {
+		events := state.Events
+
+		seen, ok := t.processed[alloc.ID]
+		if !ok {
+			seen = make(map[string]int)
+			t.processed[alloc.ID] = seen
+		}
+		start := seen[name]
+		if start >= len(events) {
+			continue
+		}
+
+		var prevType string
+		if start > 0 {
+			prevType = events[start-1].Type
+		}
+
+		for _, e := range events[start:] {
+			if prevType != "" {
+				t.counts[prevType]--
+			}
+			t.counts[e.Type]++
+			prevType = e.Type
+
+			eventTime := time.Unix(0, e.Time).UTC()
+			switch e.Type {
+			case structs.TaskReceived:
+				latencies.recordReceived(alloc.ID+"/"+name, eventTime)
+				if !submitted.IsZero() {
+					latencies.submitToReceived.record(eventTime.Sub(submitted))
+				}
+			case structs.TaskStarted:
+				if received, ok := latencies.received[alloc.ID+"/"+name]; ok {
+					latencies.receivedToStarted.record(eventTime.Sub(received))
+				}
+				latencies.rememberStarted(alloc.ID, eventTime)
+			}
+		}
+		seen[name] = len(events)
+	}
+}
+
+// emit pushes any per-task-state counts that changed since the last call.
+func (t *taskTracker) emit(client *status.Client, ts time.Time) {
+	for state, count := range t.counts {
+		if t.lastSent[state] == count {
+			continue
+		}
+		t.lastSent[state] = count
+		client.Set("task."+strings.ToLower(strings.Replace(state, " ", "_", -1)), float64(count), ts)
+	}
+}
+
+// latencyTracker accumulates the submit->received, received->started, and
+// started->running histograms described by per-task-state events.
+type latencyTracker struct {
+	received map[string]time.Time // allocID/task -> time the Received event fired
+	started  map[string]time.Time // allocID -> time the first task Started event fired
+
+	submitToReceived  *latencyHistogram
+	receivedToStarted *latencyHistogram
+	startedToRunning  *latencyHistogram
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		received:          make(map[string]time.Time),
+		started:           make(map[string]time.Time),
+		submitToReceived:  new(latencyHistogram),
+		receivedToStarted: new(latencyHistogram),
+		startedToRunning:  new(latencyHistogram),
+	}
+}
+
+func (l *latencyTracker) recordReceived(key string, ts time.Time) { l.received[key] = ts }
+
+// rememberStarted records the first Started event time for an allocation so
+// it can be paired with the allocation's later transition to ClientStatus
+// running.
+func (l *latencyTracker) rememberStarted(allocID string, ts time.Time) {
+	if _, ok := l.started[allocID]; !ok {
+		l.started[allocID] = ts
+	}
+}
+
+func (l *latencyTracker) recordRunning(allocID string, ts time.Time) {
+	started, ok := l.started[allocID]
+	if !ok {
+		return
+	}
+	l.startedToRunning.record(ts.Sub(started))
+	delete(l.started, allocID)
+}
+
+func (l *latencyTracker) emit(client *status.Client, ts time.Time) {
+	l.submitToReceived.emit(client, "latency.submit_received", ts)
+	l.receivedToStarted.emit(client, "latency.received_started", ts)
+	l.startedToRunning.emit(client, "latency.started_running", ts)
+}
+
+// latencyHistogram is a minimal running summary (count/min/max/mean) of a
+// latency distribution, emitted to the status server as a handful of gauges
+// since the status protocol only speaks point-in-time values.
+type latencyHistogram struct {
+	count int64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	if h.count == 0 || ms < h.min {
+		h.min = ms
+	}
+	if h.count == 0 || ms > h.max {
+		h.max = ms
+	}
+	h.sum += ms
+	h.count++
+}
+
+func (h *latencyHistogram) emit(client *status.Client, prefix string, ts time.Time) {
+	if h.count == 0 {
+		return
+	}
+	client.Set(prefix+".count", float64(h.count), ts)
+	client.Set(prefix+".mean_ms", h.sum/float64(h.count), ts)
+	client.Set(prefix+".min_ms", h.min, ts)
+	client.Set(prefix+".max_ms", h.max, ts)
+}
+
 func handleTeardown() int {
-	// Check the args
-	if len(os.Args) != 3 {
-		log.Fatalln("usage: nomad-bench teardown <dir>")
+	flags := flag.NewFlagSet("teardown", flag.ExitOnError)
+	nomadFlags := registerNomadFlags(flags)
+	statusAddr := flags.String("status", os.Getenv("NOMAD_BENCH_STATUS_ADDR"), "status server address for teardown metrics (optional)")
+	concurrency := flags.Int("concurrency", envIntDefault("NOMAD_BENCH_TEARDOWN_CONCURRENCY", 10), "number of concurrent deregister requests")
+	timeout := flags.Duration("teardown-timeout", envDurationDefault("NOMAD_BENCH_TEARDOWN_TIMEOUT", 5*time.Minute), "max time to wait for allocations to drain before giving up")
+	forceGC := flags.Bool("gc", envBoolDefault("NOMAD_BENCH_GC", false), "force a Nomad system GC after allocations drain")
+	if err := flags.Parse(os.Args[2:]); err != nil {
+		log.Fatalf("failed parsing flags: %v", err)
 	}
+	if flags.NArg() != 1 {
+		log.Fatalln("usage: nomad-bench teardown [flags] <dir>")
+	}
+	dir := flags.Arg(0)
+	start := time.Now()
 
 	// Get the API client
-	client, err := api.NewClient(api.DefaultConfig())
+	client, err := newNomadClient(nomadFlags)
 	if err != nil {
 		log.Fatalf("failed creating nomad client: %v", err)
 	}
 
-	// Iterate all of the jobs and stop them
+	// Iterate all of the jobs and stop them, deregistering in parallel
+	// batches so teardown of a large bench run isn't bottlenecked on
+	// request latency.
 	jobs, _, err := client.Jobs().List(nil)
 	if err != nil {
 		log.Fatalf("failed listing jobs: %v", err)
 	}
+
+	jobIDs := make(map[string]bool, len(jobs))
+	sem := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
 	for _, job := range jobs {
-		if _, _, err := client.Jobs().Deregister(job.ID, nil); err != nil {
-			log.Fatalf("failed deregistering job: %v", err)
+		jobIDs[job.ID] = true
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(id string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if _, _, err := client.Jobs().Deregister(id, nil); err != nil {
+				log.Printf("failed deregistering job %q: %v", id, err)
+			}
+		}(job.ID)
+	}
+	wg.Wait()
+
+	// Deregistering a job only requests termination; block until every
+	// allocation tied to one of our jobs reaches a terminal ClientStatus
+	// (or we give up) so we don't race the scheduler's async GC.
+	waitForDrain(client, jobIDs, *timeout)
+
+	if *forceGC {
+		if err := client.System().GarbageCollect(); err != nil {
+			log.Printf("failed forcing system gc: %v", err)
 		}
 	}
 
 	// Nuke the dir
-	if err := os.RemoveAll(os.Args[2]); err != nil {
+	if err := os.RemoveAll(dir); err != nil {
 		log.Fatalf("failed cleaning up temp dir: %v", err)
 	}
 
+	if *statusAddr != "" {
+		statusClient, err := status.NewClient(*statusAddr)
+		if err != nil {
+			log.Printf("failed contacting status server: %v", err)
+		} else {
+			statusClient.Set("teardown.duration_ms", float64(time.Since(start)/time.Millisecond), time.Now().UTC())
+			statusClient.Close()
+		}
+	}
+
 	return 0
 }
 
+// waitForDrain blocks until every allocation belonging to a job in jobIDs
+// reaches a terminal ClientStatus, or timeout elapses.
+func waitForDrain(client *api.Client, jobIDs map[string]bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for {
+		allocs, _, err := client.Allocations().List(nil)
+		if err != nil {
+			log.Printf("failed listing allocations: %v", err)
+			if time.Now().After(deadline) {
+				log.Printf("teardown-timeout elapsed before allocations could be listed")
+				return
+			}
+			time.Sleep(2 * time.Second)
+			continue
+		}
+
+		pending := 0
+		for _, alloc := range allocs {
+			if !jobIDs[alloc.JobID] {
+				continue
+			}
+			switch alloc.ClientStatus {
+			case structs.AllocClientStatusComplete, structs.AllocClientStatusFailed:
+			default:
+				pending++
+			}
+		}
+		if pending == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			log.Printf("teardown-timeout elapsed with %d allocations still draining", pending)
+			return
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
 func convertStructJob(in *structs.Job) (*api.Job, error) {
 	gob.Register([]map[string]interface{}{})
 	gob.Register([]interface{}{})
@@ -266,4 +988,137 @@ job "bench" {
 		}
 	}
 }
-`
\ No newline at end of file
+`
+
+// driverJobTemplate returns the HCL template for a single driver's matrix
+// entry. Each template takes (driver name, count, cpu, memory) via Sprintf,
+// mirroring jobTemplate's calling convention.
+func driverJobTemplate(driver string) string {
+	switch driver {
+	case "docker":
+		return dockerJobTemplate
+	case "exec":
+		return execJobTemplate
+	case "raw_exec":
+		return rawExecJobTemplate
+	case "java":
+		return javaJobTemplate
+	default:
+		log.Fatalf("unknown driver: %q", driver)
+		return ""
+	}
+}
+
+const dockerJobTemplate = `
+job "bench-%[1]s" {
+	datacenters = ["dc1"]
+
+	group "%[1]s" {
+		count = %[2]d
+
+		restart {
+			mode = "fail"
+			attempts = 0
+		}
+
+		task "bench" {
+			driver = "docker"
+
+			config {
+				image = "redis:latest"
+			}
+
+			resources {
+				cpu    = %[3]d
+				memory = %[4]d
+			}
+		}
+	}
+}
+`
+
+const execJobTemplate = `
+job "bench-%[1]s" {
+	datacenters = ["dc1"]
+
+	group "%[1]s" {
+		count = %[2]d
+
+		restart {
+			mode = "fail"
+			attempts = 0
+		}
+
+		task "bench" {
+			driver = "exec"
+
+			config {
+				command = "/bin/sleep"
+				args    = ["3600"]
+			}
+
+			resources {
+				cpu    = %[3]d
+				memory = %[4]d
+			}
+		}
+	}
+}
+`
+
+const rawExecJobTemplate = `
+job "bench-%[1]s" {
+	datacenters = ["dc1"]
+
+	group "%[1]s" {
+		count = %[2]d
+
+		restart {
+			mode = "fail"
+			attempts = 0
+		}
+
+		task "bench" {
+			driver = "raw_exec"
+
+			config {
+				command = "/bin/sleep"
+				args    = ["3600"]
+			}
+
+			resources {
+				cpu    = %[3]d
+				memory = %[4]d
+			}
+		}
+	}
+}
+`
+
+const javaJobTemplate = `
+job "bench-%[1]s" {
+	datacenters = ["dc1"]
+
+	group "%[1]s" {
+		count = %[2]d
+
+		restart {
+			mode = "fail"
+			attempts = 0
+		}
+
+		task "bench" {
+			driver = "java"
+
+			config {
+				jar_path = "local/demo.jar"
+			}
+
+			resources {
+				cpu    = %[3]d
+				memory = %[4]d
+			}
+		}
+	}
+}
+`